@@ -0,0 +1,168 @@
+package salmon
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Dialect adapts salmon's schema DDL, locking strategy, and parameter
+// binding to a specific database engine. Set Opts.Dialect to override
+// auto-detection, which inspects the driver registered against the *sql.DB.
+type Dialect interface {
+	// CreateSchemaTable returns the DDL used to create the migration
+	// history table if it doesn't already exist.
+	CreateSchemaTable(tableName string) string
+	// AcquireLock takes an exclusive lock scoped to conn so that concurrent
+	// Migrate calls against the same database don't race. It must block
+	// (or return an error) until the lock is held. The lock is scoped to
+	// the connection rather than a transaction, since it must still be
+	// held while a migration runs with no transaction open at all (see
+	// Migration.UseTx).
+	AcquireLock(ctx context.Context, conn *sql.Conn, tableName string) error
+	// ReleaseLock releases the lock taken by AcquireLock.
+	ReleaseLock(ctx context.Context, conn *sql.Conn, tableName string) error
+	// Placeholder returns the parameter marker for the i-th (1-indexed)
+	// bound argument in a query.
+	Placeholder(i int) string
+	// QuoteIdent quotes name as a safe identifier for this dialect.
+	QuoteIdent(name string) string
+}
+
+// resolveDialect returns opts.Dialect if set, otherwise detects one from db.
+func resolveDialect(db *sql.DB, opts *Opts) Dialect {
+	if opts.Dialect != nil {
+		return opts.Dialect
+	}
+	return detectDialect(db)
+}
+
+// detectDialect infers a Dialect from the type name of db's registered
+// driver, since database/sql exposes no driver name directly. It falls
+// back to SQLite, the dialect this package's own tests run against.
+func detectDialect(db *sql.DB) Dialect {
+	driverType := strings.ToLower(fmt.Sprintf("%T", db.Driver()))
+
+	switch {
+	case strings.Contains(driverType, "postgres") || strings.Contains(driverType, "pgx") || strings.Contains(driverType, "pq."):
+		return postgresDialect{}
+	case strings.Contains(driverType, "mysql"):
+		return mysqlDialect{}
+	default:
+		return sqliteDialect{}
+	}
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) CreateSchemaTable(tableName string) string {
+	return fmt.Sprintf(`
+		create table if not exists %s (
+		id integer primary key autoincrement,
+		type text not null default 'versioned',
+		version integer,
+		description text not null,
+		checksum text not null,
+		down_sql text,
+		use_tx integer not null default 1,
+		applied_at timestamp default current_timestamp not null
+		);
+		`, tableName)
+}
+
+// AcquireLock is a no-op on SQLite: *sql.DB already serializes writers on
+// the single underlying file, so Migrate's own transactions are enough.
+func (sqliteDialect) AcquireLock(ctx context.Context, conn *sql.Conn, tableName string) error {
+	return nil
+}
+
+func (sqliteDialect) ReleaseLock(ctx context.Context, conn *sql.Conn, tableName string) error {
+	return nil
+}
+
+func (sqliteDialect) Placeholder(i int) string { return fmt.Sprintf("$%d", i) }
+
+func (sqliteDialect) QuoteIdent(name string) string { return quoteDoubleQuoted(name) }
+
+type postgresDialect struct{}
+
+func (postgresDialect) CreateSchemaTable(tableName string) string {
+	return fmt.Sprintf(`
+		create table if not exists %s (
+		id bigserial primary key,
+		type text not null default 'versioned',
+		version bigint,
+		description text not null,
+		checksum text not null,
+		down_sql text,
+		use_tx boolean not null default true,
+		applied_at timestamptz not null default now()
+		);
+		`, tableName)
+}
+
+// AcquireLock takes a session-scoped advisory lock keyed off a hash of the
+// table name, so unrelated salmon instances (different TableName) don't
+// contend with each other. Unlike a transaction-scoped advisory lock, this
+// doesn't open a transaction on conn, which a NoTransaction migration
+// depends on being able to run with none open; ReleaseLock must explicitly
+// unlock it.
+func (postgresDialect) AcquireLock(ctx context.Context, conn *sql.Conn, tableName string) error {
+	_, err := conn.ExecContext(ctx, `select pg_advisory_lock(hashtext($1))`, tableName)
+	return err
+}
+
+func (postgresDialect) ReleaseLock(ctx context.Context, conn *sql.Conn, tableName string) error {
+	_, err := conn.ExecContext(ctx, `select pg_advisory_unlock(hashtext($1))`, tableName)
+	return err
+}
+
+func (postgresDialect) Placeholder(i int) string { return fmt.Sprintf("$%d", i) }
+
+func (postgresDialect) QuoteIdent(name string) string { return quoteDoubleQuoted(name) }
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) CreateSchemaTable(tableName string) string {
+	return fmt.Sprintf(`
+		create table if not exists %s (
+		id bigint auto_increment primary key,
+		type varchar(32) not null default 'versioned',
+		version bigint,
+		description text not null,
+		checksum varchar(64) not null,
+		down_sql longtext,
+		use_tx boolean not null default true,
+		applied_at timestamp not null default current_timestamp
+		);
+		`, tableName)
+}
+
+// AcquireLock takes a named, session-scoped lock via GET_LOCK, so
+// ReleaseLock must explicitly call RELEASE_LOCK on the same connection.
+func (mysqlDialect) AcquireLock(ctx context.Context, conn *sql.Conn, tableName string) error {
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `select get_lock(?, 10)`, tableName).Scan(&acquired); err != nil {
+		return err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return fmt.Errorf("could not acquire migration lock for %s", tableName)
+	}
+	return nil
+}
+
+func (mysqlDialect) ReleaseLock(ctx context.Context, conn *sql.Conn, tableName string) error {
+	_, err := conn.ExecContext(ctx, `select release_lock(?)`, tableName)
+	return err
+}
+
+func (mysqlDialect) Placeholder(i int) string { return "?" }
+
+func (mysqlDialect) QuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteDoubleQuoted(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
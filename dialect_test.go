@@ -0,0 +1,36 @@
+package salmon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectDialect(t *testing.T) {
+	db := setupDB(t)
+	defer db.Close()
+
+	dialect := detectDialect(db)
+	assert.IsType(t, sqliteDialect{}, dialect)
+}
+
+func TestDialectPlaceholderAndQuoteIdent(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		want    string
+	}{
+		{"sqlite", sqliteDialect{}, "$1"},
+		{"postgres", postgresDialect{}, "$1"},
+		{"mysql", mysqlDialect{}, "?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.dialect.Placeholder(1))
+		})
+	}
+
+	assert.Equal(t, `"my table"`, sqliteDialect{}.QuoteIdent("my table"))
+	assert.Equal(t, "`my table`", mysqlDialect{}.QuoteIdent("my table"))
+}
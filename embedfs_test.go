@@ -0,0 +1,85 @@
+package salmon
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed testdata/embedmigrations
+var embeddedMigrations embed.FS
+
+func TestMigrateFS_EmbedFS(t *testing.T) {
+	ctx := context.Background()
+	db := setupDB(t)
+	defer db.Close()
+
+	err := MigrateFS(ctx, db, embeddedMigrations, "testdata/embedmigrations", defaultOpts())
+	require.NoError(t, err)
+
+	rows, err := db.Query(fmt.Sprintf("select version from %s order by version", defaultOpts().TableName))
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		require.NoError(t, rows.Scan(&version))
+		versions = append(versions, version)
+	}
+	assert.Equal(t, []int{0, 1}, versions)
+}
+
+func TestMigrateFS_DoesNotMutateOpts(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/V0__initial_schema.sql": &fstest.MapFile{
+			Data: []byte("create table users (id integer primary key, name text not null);"),
+		},
+	}
+
+	ctx := context.Background()
+	db := setupDB(t)
+	defer db.Close()
+
+	opts := defaultOpts()
+	opts.Dir = "original_dir"
+	require.NoError(t, MigrateFS(ctx, db, fsys, "migrations", opts))
+
+	assert.Equal(t, "original_dir", opts.Dir)
+	assert.IsType(t, osFS{}, opts.FS)
+}
+
+func TestMigrateFS_MapFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/V0__initial_schema.sql": &fstest.MapFile{
+			Data: []byte("create table users (id integer primary key, name text not null);"),
+		},
+		"migrations/V1__add_email_column.sql": &fstest.MapFile{
+			Data: []byte("alter table users add email text not null;"),
+		},
+	}
+
+	ctx := context.Background()
+	db := setupDB(t)
+	defer db.Close()
+
+	err := MigrateFS(ctx, db, fsys, "migrations", defaultOpts())
+	require.NoError(t, err)
+
+	rows, err := db.Query(fmt.Sprintf("select version from %s order by version", defaultOpts().TableName))
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		require.NoError(t, rows.Scan(&version))
+		versions = append(versions, version)
+	}
+	assert.Equal(t, []int{0, 1}, versions)
+}
@@ -13,8 +13,10 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Opts struct {
@@ -22,6 +24,23 @@ type Opts struct {
 	Verbose bool // Enable verbose logging
 	Dir string // Directory containing migration files
 	FS fs.FS // Filesystem for reading migration files
+	Dialect Dialect // Database dialect; auto-detected from db when nil
+	Recursive bool // Walk subdirectories of Dir for migration files
+	// VersionParser overrides the default V<int>__<desc>.sql naming scheme
+	// for versioned migration files. It receives a file's base name and
+	// returns its version and description, or ok=false to have the file
+	// skipped entirely, e.g. a timestamp-style scheme (20240115093000_add_
+	// users.sql) or to ignore helper files living alongside migrations.
+	// Repeatable migrations keep their R__<desc>.sql naming regardless.
+	// Timestamp-style (or otherwise non-sequential-from-zero) versions will
+	// virtually always need AllowGaps set too, since they can't satisfy the
+	// default strict check that the n-th migration's version equals n.
+	VersionParser func(name string) (version int64, description string, ok bool)
+	// AllowGaps relaxes version ordering from strictly sequential
+	// (0, 1, 2, ...) to strictly increasing with no duplicates, so gaps
+	// left by migrations merged from different branches don't hard-fail a
+	// run.
+	AllowGaps bool
 }
 
 func defaultOpts() *Opts {
@@ -32,34 +51,92 @@ func defaultOpts() *Opts {
 	}
 }
 
+// MigrationType distinguishes versioned migrations (`V<int>__desc.sql`),
+// which run once in order, from repeatable migrations (`R__desc.sql`),
+// which re-run whenever their checksum changes.
+type MigrationType string
+
+const (
+	MigrationTypeVersioned  MigrationType = "versioned"
+	MigrationTypeRepeatable MigrationType = "repeatable"
+)
+
 type Migration struct {
-	Version     int64
+	Type        MigrationType
+	Version     int64 // unused for repeatable migrations
 	Description string
 	Checksum    string
-	Content     string
+	UpSQL       string
+	DownSQL     string
+	// UseTx controls whether UpSQL runs inside its own transaction (the
+	// default) or as standalone statements with no transaction open at
+	// all, for DDL that Postgres/MySQL refuse to run inside one. Set by
+	// the `-- +salmon NoTransaction` file directive.
+	UseTx bool
+}
+
+// IrreversibleMigrationError is returned by Rollback when one of the
+// migrations being rolled back has no down SQL recorded.
+type IrreversibleMigrationError struct {
+	Version     int64
+	Description string
+}
+
+func (e *IrreversibleMigrationError) Error() string {
+	return fmt.Sprintf("migration V%d__%s has no down migration", e.Version, e.Description)
 }
 
-func Migrate(ctx context.Context, db *sql.DB, migrationDir string, opts *Opts) error {
+// Migrate applies every pending migration found in opts.Dir (within
+// opts.FS) to db, in version order. opts.FS defaults to the on-disk
+// filesystem rooted at the working directory; use MigrateFS to migrate
+// from an embed.FS or any other fs.FS without mutating Opts yourself.
+func Migrate(ctx context.Context, db *sql.DB, opts *Opts) (err error) {
 	if opts == nil {
 		opts = defaultOpts()
 	}
-
-	tx, err := db.BeginTx(ctx, nil)
+	if opts.FS == nil {
+		fsOpts := *opts
+		fsOpts.FS = osFS{}
+		opts = &fsOpts
+	}
+	dialect := resolveDialect(db, opts)
+
+	// The whole run pins a single connection: it creates the schema table,
+	// holds the dialect's lock, and applies every pending migration on it,
+	// so two app instances migrating the same database at once serialize
+	// instead of racing. The lock is connection- rather than
+	// transaction-scoped, and each migration applies in its own transaction
+	// (or, for a NoTransaction migration, none at all) rather than one
+	// transaction spanning the whole run -- that would itself be an open
+	// transaction, which e.g. Postgres's CREATE INDEX CONCURRENTLY must
+	// wait to see finish before it can even start, and never would.
+	conn, err := db.Conn(ctx)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 
-	if _, err = tx.ExecContext(ctx, schema(opts.TableName)); err != nil {
-		tx.Rollback()
+	if _, err := conn.ExecContext(ctx, dialect.CreateSchemaTable(dialect.QuoteIdent(opts.TableName))); err != nil {
 		return err
 	}
 
-	if err = tx.Commit(); err != nil {
-		tx.Rollback()
+	if err := dialect.AcquireLock(ctx, conn, opts.TableName); err != nil {
 		return err
 	}
+	defer func() {
+		if releaseErr := dialect.ReleaseLock(ctx, conn, opts.TableName); releaseErr != nil && err == nil {
+			err = fmt.Errorf("failed to release migration lock: %w", releaseErr)
+		}
+	}()
 
-	appliedMigrations, err := getAppliedMigrations(db, opts.TableName)
+	return runMigrations(ctx, conn, dialect, opts)
+}
+
+// runMigrations loads and applies pending versioned and repeatable
+// migrations on conn. It assumes the schema table already exists and the
+// migration lock is held on conn.
+func runMigrations(ctx context.Context, conn *sql.Conn, dialect Dialect, opts *Opts) error {
+	appliedMigrations, err := getAppliedMigrations(ctx, conn, dialect, opts.TableName)
 	if err != nil {
 		return err
 	}
@@ -71,85 +148,332 @@ func Migrate(ctx context.Context, db *sql.DB, migrationDir string, opts *Opts) e
 		return err
 	}
 
-	files, err := fs.Glob(opts.FS, path.Join(opts.Dir, "*.sql"))
+	migrations, repeatables, err := loadMigrations(opts)
 	if err != nil {
 		return err
 	}
 
+	appliedByVersion := make(map[int64]Migration, len(appliedMigrations))
+	for _, applied := range appliedMigrations {
+		appliedByVersion[applied.Version] = applied
+	}
+
 	var migrationsToApply []Migration
-	for i, file := range files {
-		version, description, err := parseMigrationFile(file)
-		if err != nil {
-			return err
+	var prevVersion int64 = -1
+	for i, migration := range migrations {
+		if opts.AllowGaps {
+			if migration.Version <= prevVersion {
+				return fmt.Errorf("incorrect version number: V%d__%s", migration.Version, migration.Description)
+			}
+		} else if migration.Version != int64(i) {
+			return fmt.Errorf("incorrect version number: V%d__%s", migration.Version, migration.Description)
 		}
+		prevVersion = migration.Version
 
-		if version != int64(i) {
-			err := fmt.Errorf("incorrect version number: %s", filepath.Base(file))
+		if applied, ok := appliedByVersion[migration.Version]; ok {
+			if applied.Checksum != migration.Checksum {
+				return fmt.Errorf("checksum does not match expected value: V%d__%s", migration.Version, migration.Description)
+			}
+			continue
+		}
+		migrationsToApply = append(migrationsToApply, migration)
+	}
+
+	for _, migration := range migrationsToApply {
+		if err := applyMigration(ctx, conn, dialect, migration, opts.TableName); err != nil {
 			return err
 		}
+	}
 
-		f, err := opts.FS.Open(file)
-		if err != nil {
+	appliedRepeatables, err := getAppliedRepeatables(ctx, conn, dialect, opts.TableName)
+	if err != nil {
+		return err
+	}
+
+	for _, repeatable := range repeatables {
+		if checksum, ok := appliedRepeatables[repeatable.Description]; ok && checksum == repeatable.Checksum {
+			continue
+		}
+		if err := applyRepeatable(ctx, conn, dialect, repeatable, opts.TableName); err != nil {
 			return err
 		}
-		defer f.Close()
+	}
+
+	return nil
+}
+
+// MigrateFS applies every pending migration found under dir in fsys to db.
+// It is a convenience wrapper around Migrate for callers migrating from an
+// embed.FS (e.g. `//go:embed migrations/*.sql`) or any other fs.FS, sparing
+// them from having to set opts.FS and opts.Dir themselves. opts is left
+// unmodified; MigrateFS applies fsys and dir to a copy.
+func MigrateFS(ctx context.Context, db *sql.DB, fsys fs.FS, dir string, opts *Opts) error {
+	if opts == nil {
+		opts = defaultOpts()
+	}
+	fsOpts := *opts
+	fsOpts.FS = fsys
+	fsOpts.Dir = dir
+
+	return Migrate(ctx, db, &fsOpts)
+}
+
+// Rollback reverts the last steps applied migrations, in reverse order, by
+// executing their recorded down SQL and removing their rows from the schema
+// history table. Each down migration runs the same way its up migration did:
+// inside its own transaction, or, if it was recorded as NoTransaction (see
+// Migration.UseTx), as standalone statements with no transaction open at
+// all, so e.g. a `DROP INDEX CONCURRENTLY` down migration doesn't fail the
+// way it would inside one. Rollback holds the dialect's migration lock for
+// its duration, the same as Migrate, so the two can't race against each
+// other. It returns an *IrreversibleMigrationError if any of the migrations
+// being rolled back has no down SQL.
+func Rollback(ctx context.Context, db *sql.DB, steps int, opts *Opts) (err error) {
+	if opts == nil {
+		opts = defaultOpts()
+	}
+	if steps <= 0 {
+		return fmt.Errorf("steps must be greater than 0")
+	}
+	dialect := resolveDialect(db, opts)
+	table := dialect.QuoteIdent(opts.TableName)
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := dialect.AcquireLock(ctx, conn, opts.TableName); err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := dialect.ReleaseLock(ctx, conn, opts.TableName); releaseErr != nil && err == nil {
+			err = fmt.Errorf("failed to release migration lock: %w", releaseErr)
+		}
+	}()
 
-		content, err := io.ReadAll(f)
-		if err != nil {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(
+		`select version, description, down_sql, use_tx from %s where type = 'versioned' and version > -1 order by version desc limit %s`,
+		table, dialect.Placeholder(1)), steps)
+	if err != nil {
+		return err
+	}
+
+	type appliedMigration struct {
+		version     int64
+		description string
+		downSQL     sql.NullString
+		useTx       bool
+	}
+
+	var toRollback []appliedMigration
+	for rows.Next() {
+		var m appliedMigration
+		if err := rows.Scan(&m.version, &m.description, &m.downSQL, &m.useTx); err != nil {
+			rows.Close()
 			return err
 		}
-		checksum := calculateChecksum(content)
+		toRollback = append(toRollback, m)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
 
-		if i < len(appliedMigrations) {
-			migration := appliedMigrations[i]
-			if migration.Checksum != checksum {
-				err := fmt.Errorf("checksum does not match expected value: %s", file)
+	for _, m := range toRollback {
+		if !m.downSQL.Valid || m.downSQL.String == "" {
+			return &IrreversibleMigrationError{Version: m.version, Description: m.description}
+		}
+	}
+
+	for _, m := range toRollback {
+		if m.useTx {
+			tx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, m.downSQL.String); err != nil {
+				tx.Rollback()
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`delete from %s where version = %s`, table, dialect.Placeholder(1)), m.version); err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := tx.Commit(); err != nil {
 				return err
 			}
 			continue
 		}
-		migrationsToApply = append(migrationsToApply, Migration{
-			Version:     version,
-			Description: description,
-			Checksum:    checksum,
-			Content:     string(content),
-		})
+
+		for _, statement := range splitStatements(m.downSQL.String) {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`delete from %s where version = %s`, table, dialect.Placeholder(1)), m.version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
 	}
 
-	if len(migrationsToApply) == 0 {
-		return nil
+	return nil
+}
+
+// MigrationStatus reports whether a single versioned migration file has
+// been applied and, if so, whether its on-disk checksum still matches what
+// was recorded at apply time.
+type MigrationStatus struct {
+	Version       int64
+	Description   string
+	Applied       bool
+	AppliedAt     time.Time
+	ChecksumMatch bool
+}
+
+// Status reports the status of every versioned migration file found in
+// opts.Dir against opts.TableName's history, without mutating the database. It
+// fails if the history table doesn't exist yet; run Migrate first.
+func Status(ctx context.Context, db *sql.DB, opts *Opts) ([]MigrationStatus, error) {
+	if opts == nil {
+		opts = defaultOpts()
 	}
 
-	for _, migration := range migrationsToApply {
-		if err := applyMigration(ctx, db, migration, opts.TableName); err != nil {
-			return err
+	migrations, _, err := loadMigrations(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := getAppliedMigrationRecords(ctx, db, resolveDialect(db, opts), opts.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{
+			Version:     migration.Version,
+			Description: migration.Description,
+		}
+
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.appliedAt
+			status.ChecksumMatch = record.checksum == migration.Checksum
 		}
+
+		statuses = append(statuses, status)
 	}
 
-	return releaseLock(ctx, db, opts.TableName, nil)
+	return statuses, nil
 }
 
-func releaseLock(ctx context.Context, db *sql.DB, tableName string, err error) error {
-	_, lockErr := db.ExecContext(ctx, fmt.Sprintf(`delete from %s where version = -1;`, tableName))
-	if lockErr == nil {
+// Validate walks the versioned migration files in opts.Dir against
+// opts.TableName's history and returns the first problem found: a gap
+// (unless opts.AllowGaps is set) or out-of-order version among the files,
+// a checksum mismatch on an
+// already-applied file, or a version recorded as applied whose file is
+// missing from disk. It makes no changes to the database.
+func Validate(ctx context.Context, db *sql.DB, opts *Opts) error {
+	if opts == nil {
+		opts = defaultOpts()
+	}
+	dialect := resolveDialect(db, opts)
+
+	migrations, _, err := loadMigrations(opts)
+	if err != nil {
 		return err
 	}
+
+	byVersion := make(map[int64]Migration, len(migrations))
+	var prevVersion int64 = -1
+	for i, migration := range migrations {
+		if opts.AllowGaps {
+			if migration.Version <= prevVersion {
+				return fmt.Errorf("gap or out-of-order version in migration files: expected greater than %d, got %d", prevVersion, migration.Version)
+			}
+		} else if migration.Version != int64(i) {
+			return fmt.Errorf("gap or out-of-order version in migration files: expected %d, got %d", i, migration.Version)
+		}
+		prevVersion = migration.Version
+		byVersion[migration.Version] = migration
+	}
+
+	applied, err := getAppliedMigrationRecords(ctx, db, dialect, opts.TableName)
 	if err != nil {
-		return fmt.Errorf("ATTENTION: could not release lock! please run `delete from %s where version=-1;` and try again.\noriginal err: %s\nfrom: %s", tableName, lockErr, err)
+		return err
 	}
-	return fmt.Errorf("ATTENTION: could not release lock! please run `delete from %s where version=-1;` and try again.\noriginal err: %s", tableName, lockErr)
+
+	for version, record := range applied {
+		migration, ok := byVersion[version]
+		if !ok {
+			return fmt.Errorf("version %d is recorded as applied in %s but its migration file is missing from %s", version, opts.TableName, opts.Dir)
+		}
+		if migration.Checksum != record.checksum {
+			return fmt.Errorf("checksum does not match expected value: V%d__%s", migration.Version, migration.Description)
+		}
+	}
+
+	return nil
 }
 
-func applyMigration(ctx context.Context, db *sql.DB, migration Migration, tablename string) error {
-	tx, err := db.BeginTx(ctx, nil)
+type appliedMigrationRecord struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// getAppliedMigrationRecords reads the full applied-migration history
+// (checksum and applied_at) for versioned migrations, keyed by version, for
+// use by read-only inspection APIs.
+func getAppliedMigrationRecords(ctx context.Context, db *sql.DB, dialect Dialect, tableName string) (map[int64]appliedMigrationRecord, error) {
+	records := map[int64]appliedMigrationRecord{}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT version, checksum, applied_at FROM %s where type = 'versioned' and version > -1 order by version",
+		dialect.QuoteIdent(tableName)))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int64
+		var record appliedMigrationRecord
+		if err := rows.Scan(&version, &record.checksum, &record.appliedAt); err != nil {
+			return nil, err
+		}
+		records[version] = record
 	}
 
+	return records, rows.Err()
+}
+
+// applyMigration runs migration.UpSQL and records its history row. By
+// default UpSQL and its history row are applied together in one transaction
+// on conn. When migration.UseTx is false (the file carried a
+// `-- +salmon NoTransaction` directive), its statements are split and run
+// individually against conn with no transaction open at all -- required for
+// DDL such as Postgres's CREATE INDEX CONCURRENTLY, which refuses to run
+// inside one -- and the history row is then recorded in its own follow-up
+// transaction, so that if a later migration in this run fails, the rollback
+// of that failure can't also erase the record of DDL that already ran and
+// can't be undone.
+func applyMigration(ctx context.Context, conn *sql.Conn, dialect Dialect, migration Migration, tableName string) error {
+	table := dialect.QuoteIdent(tableName)
+
 	var exists bool
-	err = tx.QueryRowContext(ctx, fmt.Sprintf(`select exists(select 1 from %s where version = $1)`, tablename), migration.Version).Scan(&exists)
+	err := conn.QueryRowContext(ctx, fmt.Sprintf(`select exists(select 1 from %s where version = %s)`, table, dialect.Placeholder(1)), migration.Version).Scan(&exists)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
@@ -157,15 +481,82 @@ func applyMigration(ctx context.Context, db *sql.DB, migration Migration, tablen
 		return nil
 	}
 
-	if _, err = tx.ExecContext(ctx, `
-        insert into salmon_schema_history (version, description, checksum)
-        values ($1, $2, $3)`,
-		migration.Version, migration.Description, migration.Checksum); err != nil {
+	var downSQL sql.NullString
+	if migration.DownSQL != "" {
+		downSQL = sql.NullString{String: migration.DownSQL, Valid: true}
+	}
+
+	if migration.UseTx {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migration.UpSQL); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := insertMigrationHistory(ctx, tx, dialect, migration, downSQL, tableName); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+
+	for _, statement := range splitStatements(migration.UpSQL) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := insertMigrationHistory(ctx, tx, dialect, migration, downSQL, tableName); err != nil {
 		tx.Rollback()
 		return err
 	}
+	return tx.Commit()
+}
+
+// insertMigrationHistory records a versioned migration's history row on tx,
+// including whether it ran with UseTx so Rollback can run its down SQL the
+// same way.
+func insertMigrationHistory(ctx context.Context, tx *sql.Tx, dialect Dialect, migration Migration, downSQL sql.NullString, tableName string) error {
+	table := dialect.QuoteIdent(tableName)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+        insert into %s (type, version, description, checksum, down_sql, use_tx)
+        values ('versioned', %s, %s, %s, %s, %s)`, table,
+		dialect.Placeholder(1), dialect.Placeholder(2), dialect.Placeholder(3), dialect.Placeholder(4), dialect.Placeholder(5)),
+		migration.Version, migration.Description, migration.Checksum, downSQL, migration.UseTx)
+	return err
+}
+
+// applyRepeatable (re-)runs a repeatable migration and replaces its history
+// row, keyed by description since repeatables have no version. It runs in
+// its own transaction on conn.
+func applyRepeatable(ctx context.Context, conn *sql.Conn, dialect Dialect, migration Migration, tableName string) error {
+	table := dialect.QuoteIdent(tableName)
 
-	if _, err = tx.ExecContext(ctx, migration.Content); err != nil {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`delete from %s where type = 'repeatable' and description = %s`, table, dialect.Placeholder(1)), migration.Description); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+        insert into %s (type, description, checksum)
+        values ('repeatable', %s, %s)`, table, dialect.Placeholder(1), dialect.Placeholder(2)),
+		migration.Description, migration.Checksum); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, migration.UpSQL); err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -178,27 +569,306 @@ func calculateChecksum(content []byte) string {
 	return hex.EncodeToString(checksum[:])
 }
 
-func parseMigrationFile(filename string) (int64, string, error) {
+// parseMigrationFile extracts the type, version and description from a
+// migration filename. It accepts the versioned `V<int>__<desc>.sql` layout,
+// its `.up.sql` / `.down.sql` paired variants, and the repeatable
+// `R__<desc>.sql` layout (whose version is meaningless and always 0).
+func parseMigrationFile(filename string) (MigrationType, int64, string, error) {
 	basename := filepath.Base(filename)
 
-	parts := strings.SplitN(basename, "__", 2) // split version and description
+	name := basename
+	for _, suffix := range []string{".up.sql", ".down.sql", ".sql"} {
+		if strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+
+	parts := strings.SplitN(name, "__", 2) // split prefix and description
 	if len(parts) != 2 {
-		return 0, "", fmt.Errorf("invalid filename format: %s", basename)
+		return "", 0, "", fmt.Errorf("invalid filename format: %s", basename)
+	}
+
+	prefix, description := parts[0], parts[1]
+
+	switch {
+	case prefix == "R":
+		return MigrationTypeRepeatable, 0, description, nil
+	case strings.HasPrefix(prefix, "V"):
+		version, err := strconv.Atoi(prefix[1:]) // skip leading "V"
+		if err != nil {
+			return "", 0, "", fmt.Errorf("invalid filename format: %s", basename)
+		}
+		return MigrationTypeVersioned, int64(version), description, nil
+	default:
+		return "", 0, "", fmt.Errorf("invalid filename format: %s", basename)
+	}
+}
+
+// collectMigrationFiles lists the *.sql files under opts.Dir, walking
+// subdirectories when opts.Recursive is set.
+func collectMigrationFiles(opts *Opts) ([]string, error) {
+	if !opts.Recursive {
+		return fs.Glob(opts.FS, path.Join(opts.Dir, "*.sql"))
 	}
 
-	version, err := strconv.Atoi(parts[0][1:]) // skip leading "V"
+	var files []string
+	err := fs.WalkDir(opts.FS, opts.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".sql") {
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
 	if err != nil {
-		return 0, "", fmt.Errorf("invalid filename format: %s", basename)
+		return nil, err
 	}
 
-	description := parts[1]
-	return int64(version), description, nil
+	return files, nil
 }
 
-func getAppliedMigrations(db *sql.DB, tableName string) ([]Migration, error) {
+// classifyMigrationFile determines a migration file's type, version and
+// description. Repeatable migrations are always recognized by their
+// R__<desc>.sql name; versioned migrations use opts.VersionParser when set,
+// falling back to the default V<int>__<desc>.sql naming otherwise. ok is
+// false when the file isn't a recognized migration and should be skipped,
+// which only opts.VersionParser can signal.
+func classifyMigrationFile(file string, opts *Opts) (MigrationType, int64, string, bool, error) {
+	basename := filepath.Base(file)
+
+	if strings.HasPrefix(basename, "R__") {
+		_, _, description, err := parseMigrationFile(file)
+		if err != nil {
+			return "", 0, "", false, err
+		}
+		return MigrationTypeRepeatable, 0, description, true, nil
+	}
+
+	if opts.VersionParser != nil {
+		version, description, ok := opts.VersionParser(basename)
+		if !ok {
+			return "", 0, "", false, nil
+		}
+		return MigrationTypeVersioned, version, description, true, nil
+	}
+
+	kind, version, description, err := parseMigrationFile(file)
+	if err != nil {
+		return "", 0, "", false, err
+	}
+	return kind, version, description, true, nil
+}
+
+// loadMigrations scans opts.Dir for migration files and assembles them into
+// a version-ordered slice of versioned Migration (pairing `.up.sql` /
+// `.down.sql` files and splitting single-file migrations on the
+// `-- +salmon Down` marker when present) and a slice of repeatable
+// Migration ordered by file path.
+func loadMigrations(opts *Opts) ([]Migration, []Migration, error) {
+	files, err := collectMigrationFiles(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type pair struct {
+		description string
+		upFile      string
+		downFile    string
+	}
+
+	byVersion := map[int64]*pair{}
+	var order []int64
+	var repeatableFiles []string
+
+	for _, file := range files {
+		kind, version, description, recognized, err := classifyMigrationFile(file, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !recognized {
+			continue
+		}
+
+		if kind == MigrationTypeRepeatable {
+			repeatableFiles = append(repeatableFiles, file)
+			continue
+		}
+
+		p, ok := byVersion[version]
+		if !ok {
+			p = &pair{}
+			byVersion[version] = p
+			order = append(order, version)
+		}
+
+		if strings.HasSuffix(file, ".down.sql") {
+			p.downFile = file
+			continue
+		}
+
+		if p.upFile != "" {
+			return nil, nil, fmt.Errorf("duplicate version %d: %s and %s", version, filepath.Base(p.upFile), filepath.Base(file))
+		}
+		p.description = description
+		p.upFile = file
+	}
+
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+
+	migrations := make([]Migration, 0, len(order))
+	for _, version := range order {
+		p := byVersion[version]
+		if p.upFile == "" {
+			return nil, nil, fmt.Errorf("missing up migration for version %d", version)
+		}
+
+		upContent, err := readFile(opts.FS, p.upFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		upSQL, downSQL := string(upContent), ""
+		if p.downFile != "" {
+			downContent, err := readFile(opts.FS, p.downFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			downSQL = string(downContent)
+		} else {
+			upSQL, downSQL = splitUpDown(string(upContent))
+		}
+
+		migrations = append(migrations, Migration{
+			Type:        MigrationTypeVersioned,
+			Version:     version,
+			Description: p.description,
+			Checksum:    calculateChecksum([]byte(upSQL)),
+			UpSQL:       upSQL,
+			DownSQL:     downSQL,
+			UseTx:       !noTransactionDirective.MatchString(upSQL),
+		})
+	}
+
+	sort.Strings(repeatableFiles)
+
+	repeatables := make([]Migration, 0, len(repeatableFiles))
+	for _, file := range repeatableFiles {
+		_, _, description, err := parseMigrationFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		content, err := readFile(opts.FS, file)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		repeatables = append(repeatables, Migration{
+			Type:        MigrationTypeRepeatable,
+			Description: description,
+			Checksum:    calculateChecksum(content),
+			UpSQL:       string(content),
+		})
+	}
+
+	return migrations, repeatables, nil
+}
+
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+var downMarker = regexp.MustCompile(`(?m)^--\s*\+salmon\s+Down\s*$`)
+
+var (
+	noTransactionDirective = regexp.MustCompile(`(?m)^--\s*\+salmon\s+NoTransaction\s*$`)
+	statementBeginMarker   = regexp.MustCompile(`(?m)^--\s*\+salmon\s+StatementBegin\s*$`)
+	statementEndMarker     = regexp.MustCompile(`(?m)^--\s*\+salmon\s+StatementEnd\s*$`)
+)
+
+// splitStatements breaks a NoTransaction migration's SQL into the
+// individual statements applyMigration runs outside a transaction.
+// Everything between a `-- +salmon StatementBegin` / `-- +salmon
+// StatementEnd` pair is kept as one statement verbatim, so a body
+// containing its own semicolons (a Postgres function, say) isn't split
+// apart; everything else is split on ";".
+func splitStatements(content string) []string {
+	var statements []string
+
+	remaining := content
+	for {
+		beginLoc := statementBeginMarker.FindStringIndex(remaining)
+		if beginLoc == nil {
+			statements = append(statements, splitOnSemicolons(remaining)...)
+			break
+		}
+		statements = append(statements, splitOnSemicolons(remaining[:beginLoc[0]])...)
+
+		rest := remaining[beginLoc[1]:]
+		endLoc := statementEndMarker.FindStringIndex(rest)
+		if endLoc == nil {
+			if stmt := strings.TrimSpace(rest); stmt != "" {
+				statements = append(statements, stmt)
+			}
+			break
+		}
+
+		if stmt := strings.TrimSpace(rest[:endLoc[0]]); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		remaining = rest[endLoc[1]:]
+	}
+
+	return statements
+}
+
+// splitOnSemicolons splits s into statements on ";", keeping each
+// statement's trailing ";" intact (a trailing fragment with no ";" of its
+// own, e.g. a file missing its final terminator, is kept without adding
+// one).
+func splitOnSemicolons(s string) []string {
+	var statements []string
+	for {
+		idx := strings.Index(s, ";")
+		if idx == -1 {
+			break
+		}
+		if stmt := strings.TrimSpace(s[:idx+1]); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		s = s[idx+1:]
+	}
+	if stmt := strings.TrimSpace(s); stmt != "" {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
+
+// splitUpDown splits a single migration file's content on the
+// `-- +salmon Down` marker. If the marker isn't present, the whole content
+// is treated as up SQL and down is empty, meaning the migration is
+// irreversible.
+func splitUpDown(content string) (up string, down string) {
+	loc := downMarker.FindStringIndex(content)
+	if loc == nil {
+		return content, ""
+	}
+	return content[:loc[0]], content[loc[1]:]
+}
+
+func getAppliedMigrations(ctx context.Context, conn *sql.Conn, dialect Dialect, tableName string) ([]Migration, error) {
 	migrations := []Migration{}
 
-	rows, err := db.Query(fmt.Sprintf("SELECT version, description, checksum FROM %s where version > -1 order by version", tableName))
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, description, checksum FROM %s where type = 'versioned' and version > -1 order by version", dialect.QuoteIdent(tableName)))
 	if err != nil {
 		return nil, err
 	}
@@ -215,16 +885,26 @@ func getAppliedMigrations(db *sql.DB, tableName string) ([]Migration, error) {
 	return migrations, nil
 }
 
-func schema(tableName string) string {
-	return fmt.Sprintf(`
-		create table if not exists %s (
-		id integer primary key autoincrement,
-		version integer not null,
-		description text not null,
-		checksum text not null,
-		applied_at timestamp default current_timestamp not null
-		);
-		`, tableName)
+// getAppliedRepeatables returns the checksum recorded for each applied
+// repeatable migration, keyed by description.
+func getAppliedRepeatables(ctx context.Context, conn *sql.Conn, dialect Dialect, tableName string) (map[string]string, error) {
+	checksums := map[string]string{}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT description, checksum FROM %s where type = 'repeatable'", dialect.QuoteIdent(tableName)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var description, checksum string
+		if err := rows.Scan(&description, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[description] = checksum
+	}
+
+	return checksums, rows.Err()
 }
 
 var (
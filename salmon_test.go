@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -25,6 +27,11 @@ var (
 		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);",
 		"Vone__add_email_column.sql": "alter table users add email text not null;",
 	}
+	duplicateVersion = MigrationFiles{
+		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);",
+		"V1__first.sql": "alter table users add email text not null;",
+		"V1__second.sql": "alter table users add age integer not null;",
+	}
 	invalidSql = MigrationFiles{
 		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);",
 		"V1__add_email_column.sql": "alter table users add",
@@ -34,6 +41,22 @@ var (
 		"V1__add_email_column.sql": "alter table users add email text not null;",
 		"V2__add_age_column.sql": "alter table users add age integer not null;",
 	}
+	reversibleMarker = MigrationFiles{
+		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);\n-- +salmon Down\ndrop table users;",
+		"V1__add_email_column.sql": "alter table users add email text not null;\n-- +salmon Down\nalter table users drop column email;",
+	}
+	reversiblePairedFiles = MigrationFiles{
+		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);",
+		"V1__add_email_column.up.sql": "alter table users add email text not null;",
+		"V1__add_email_column.down.sql": "alter table users drop column email;",
+	}
+	reversibleNoTransaction = MigrationFiles{
+		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);",
+		"V1__add_name_index.sql": "-- +salmon NoTransaction\ncreate index idx_users_name on users(name);\n-- +salmon Down\ndrop index idx_users_name;",
+	}
+	irreversible = MigrationFiles{
+		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);",
+	}
 	validLong = MigrationFiles{
 		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);",
 		"V1__add_email_column.sql": "alter table users add email text not null;",
@@ -63,6 +86,7 @@ func setupMigrationsDir(t *testing.T, files MigrationFiles) string {
 
 	for filename, content := range files {
 		filePath := filepath.Join(dir, filename)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0755))
 		err := os.WriteFile(filePath, []byte(content), 0644)
 		require.NoError(t, err)
 	}
@@ -80,7 +104,7 @@ func TestMigrate(t *testing.T) {
 		{
 			name: "out of order migrations",
 			files: outOfOrder,
-			expectedError: "invalid version: expected 2, got 3",
+			expectedError: "incorrect version number: V3__add_age_column",
 			expectedVersions: nil,
 		},
 		{
@@ -89,6 +113,12 @@ func TestMigrate(t *testing.T) {
 			expectedError: "invalid filename format: Vone__add_email_column.sql",
 			expectedVersions: nil,
 		},
+		{
+			name: "duplicate version",
+			files: duplicateVersion,
+			expectedError: "duplicate version 1: V1__first.sql and V1__second.sql",
+			expectedVersions: nil,
+		},
 		{
 			name: "invalid SQL in migration",
 			files: invalidSql,
@@ -149,3 +179,328 @@ func TestMigrate(t *testing.T) {
 
 }
 
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         MigrationFiles
+		steps         int
+		expectedError string
+		remaining     []int
+	}{
+		{
+			name:      "rollback marker-based migration",
+			files:     reversibleMarker,
+			steps:     1,
+			remaining: []int{0},
+		},
+		{
+			name:      "rollback paired up/down files",
+			files:     reversiblePairedFiles,
+			steps:     1,
+			remaining: []int{0},
+		},
+		{
+			name:      "rollback NoTransaction migration",
+			files:     reversibleNoTransaction,
+			steps:     1,
+			remaining: []int{0},
+		},
+		{
+			name:          "rollback migration without down SQL",
+			files:         irreversible,
+			steps:         1,
+			expectedError: "migration V0__initial_schema has no down migration",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := setupDB(t)
+			defer db.Close()
+
+			dir := setupMigrationsDir(t, tt.files)
+			defer os.RemoveAll(dir)
+
+			opts := defaultOpts()
+			opts.Dir = dir
+			require.NoError(t, Migrate(ctx, db, opts))
+
+			err := Rollback(ctx, db, tt.steps, opts)
+
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.expectedError, err.Error())
+				var irreversibleErr *IrreversibleMigrationError
+				assert.ErrorAs(t, err, &irreversibleErr)
+				return
+			}
+			require.NoError(t, err)
+
+			rows, err := db.Query(fmt.Sprintf("select version from %s order by version", opts.TableName))
+			require.NoError(t, err)
+			defer rows.Close()
+
+			var versions []int
+			for rows.Next() {
+				var version int
+				require.NoError(t, rows.Scan(&version))
+				versions = append(versions, version)
+			}
+			assert.Equal(t, tt.remaining, versions)
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	db := setupDB(t)
+	defer db.Close()
+
+	dir := setupMigrationsDir(t, validMigrations)
+	defer os.RemoveAll(dir)
+
+	opts := defaultOpts()
+	opts.Dir = dir
+	require.NoError(t, Migrate(ctx, db, opts))
+
+	statuses, err := Status(ctx, db, opts)
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+
+	for i, status := range statuses {
+		assert.Equal(t, int64(i), status.Version)
+		assert.True(t, status.Applied)
+		assert.True(t, status.ChecksumMatch)
+		assert.False(t, status.AppliedAt.IsZero())
+	}
+
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "V2__add_age_column.sql"),
+		[]byte("alter table users add age integer;"),
+		0644,
+	))
+
+	statuses, err = Status(ctx, db, opts)
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	assert.False(t, statuses[2].ChecksumMatch)
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	db := setupDB(t)
+	defer db.Close()
+
+	dir := setupMigrationsDir(t, validMigrations)
+	defer os.RemoveAll(dir)
+
+	opts := defaultOpts()
+	opts.Dir = dir
+	require.NoError(t, Migrate(ctx, db, opts))
+	require.NoError(t, Validate(ctx, db, opts))
+
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "V2__add_age_column.sql"),
+		[]byte("alter table users add age integer;"),
+		0644,
+	))
+	err := Validate(ctx, db, opts)
+	require.Error(t, err)
+	assert.Equal(t, "checksum does not match expected value: V2__add_age_column", err.Error())
+}
+
+func TestRepeatableMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := setupDB(t)
+	defer db.Close()
+
+	files := MigrationFiles{
+		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);",
+		"R__user_count_view.sql": "create view if not exists user_count as select count(*) as n from users;",
+	}
+	dir := setupMigrationsDir(t, files)
+	defer os.RemoveAll(dir)
+
+	opts := defaultOpts()
+	opts.Dir = dir
+	require.NoError(t, Migrate(ctx, db, opts))
+
+	var checksum string
+	require.NoError(t, db.QueryRow(fmt.Sprintf(
+		"select checksum from %s where type = 'repeatable' and description = 'user_count_view'", opts.TableName,
+	)).Scan(&checksum))
+	assert.NotEmpty(t, checksum)
+
+	// Re-running with an unchanged repeatable migration should be a no-op.
+	require.NoError(t, Migrate(ctx, db, opts))
+
+	// Changing the repeatable migration's content should re-run it.
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "R__user_count_view.sql"),
+		[]byte("create view if not exists user_count as select count(*) + 1 as n from users;"),
+		0644,
+	))
+	require.NoError(t, Migrate(ctx, db, opts))
+
+	var newChecksum string
+	require.NoError(t, db.QueryRow(fmt.Sprintf(
+		"select checksum from %s where type = 'repeatable' and description = 'user_count_view'", opts.TableName,
+	)).Scan(&newChecksum))
+	assert.NotEqual(t, checksum, newChecksum)
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "single statement",
+			content: "create index idx_users_age on users(age);",
+			want:    []string{"create index idx_users_age on users(age);"},
+		},
+		{
+			name:    "multiple statements split on semicolons",
+			content: "create index idx_a on users(a);\ncreate index idx_b on users(b);",
+			want: []string{
+				"create index idx_a on users(a);",
+				"create index idx_b on users(b);",
+			},
+		},
+		{
+			name: "statement begin/end block kept intact despite internal semicolons",
+			content: "-- +salmon NoTransaction\n" +
+				"create index idx_users_age on users(age);\n" +
+				"-- +salmon StatementBegin\n" +
+				"create trigger trg_users before insert on users begin\n" +
+				"  select raise(abort, 'no;op');\n" +
+				"end;\n" +
+				"-- +salmon StatementEnd\n",
+			want: []string{
+				"-- +salmon NoTransaction\ncreate index idx_users_age on users(age);",
+				"create trigger trg_users before insert on users begin\n  select raise(abort, 'no;op');\nend;",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, splitStatements(tt.content))
+		})
+	}
+}
+
+func TestMigrateRecursive(t *testing.T) {
+	ctx := context.Background()
+	db := setupDB(t)
+	defer db.Close()
+
+	files := MigrationFiles{
+		"V0__initial_schema.sql": "create table users (id integer primary key, name text not null);",
+		"users/V1__add_email_column.sql": "alter table users add email text not null;",
+		"users/V2__add_age_column.sql": "alter table users add age integer not null;",
+	}
+	dir := setupMigrationsDir(t, files)
+	defer os.RemoveAll(dir)
+
+	opts := defaultOpts()
+	opts.Dir = dir
+	opts.Recursive = true
+	require.NoError(t, Migrate(ctx, db, opts))
+
+	rows, err := db.Query(fmt.Sprintf("select version from %s order by version", opts.TableName))
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		require.NoError(t, rows.Scan(&version))
+		versions = append(versions, version)
+	}
+	assert.Equal(t, []int{0, 1, 2}, versions)
+}
+
+func TestMigrateCustomVersionParser(t *testing.T) {
+	ctx := context.Background()
+	db := setupDB(t)
+	defer db.Close()
+
+	files := MigrationFiles{
+		"20240101000000_initial_schema.sql": "create table users (id integer primary key, name text not null);",
+		"20240102000000_add_email.sql": "alter table users add email text not null;",
+		"schema_notes.sql": "-- just notes for humans, not a real migration",
+	}
+	dir := setupMigrationsDir(t, files)
+	defer os.RemoveAll(dir)
+
+	timestampName := regexp.MustCompile(`^(\d{14})_(.+)\.sql$`)
+
+	opts := defaultOpts()
+	opts.Dir = dir
+	// Timestamp-style versions can never satisfy the default strict
+	// "n-th migration has version n" check, so AllowGaps is required
+	// alongside a custom VersionParser like this one.
+	opts.AllowGaps = true
+	opts.VersionParser = func(name string) (int64, string, bool) {
+		m := timestampName.FindStringSubmatch(name)
+		if m == nil {
+			return 0, "", false
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, "", false
+		}
+		return version, m[2], true
+	}
+	require.NoError(t, Migrate(ctx, db, opts))
+
+	rows, err := db.Query(fmt.Sprintf("select version from %s order by version", opts.TableName))
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var versions []int64
+	for rows.Next() {
+		var version int64
+		require.NoError(t, rows.Scan(&version))
+		versions = append(versions, version)
+	}
+	assert.Equal(t, []int64{20240101000000, 20240102000000}, versions)
+}
+
+func TestMigrateAllowGaps(t *testing.T) {
+	ctx := context.Background()
+	db := setupDB(t)
+	defer db.Close()
+
+	files := MigrationFiles{
+		"V0__initial_schema.sql":    "create table users (id integer primary key, name text not null);",
+		"V5__add_email_column.sql": "alter table users add email text not null;",
+	}
+	dir := setupMigrationsDir(t, files)
+	defer os.RemoveAll(dir)
+
+	opts := defaultOpts()
+	opts.Dir = dir
+
+	err := Migrate(ctx, db, opts)
+	require.Error(t, err)
+	assert.Equal(t, "incorrect version number: V5__add_email_column", err.Error())
+
+	opts.AllowGaps = true
+	require.NoError(t, Migrate(ctx, db, opts))
+
+	rows, err := db.Query(fmt.Sprintf("select version from %s order by version", opts.TableName))
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		require.NoError(t, rows.Scan(&version))
+		versions = append(versions, version)
+	}
+	assert.Equal(t, []int{0, 5}, versions)
+}